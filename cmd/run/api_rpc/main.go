@@ -53,4 +53,4 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go_im/im/client"
+	"go_im/im/dao"
+	"go_im/pkg/db"
+	"go_im/pkg/logger"
+	"go_im/service"
+	"go_im/service/gateway_service"
+	"go_im/service/rpc"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsAddr is where this process exposes its Prometheus metrics for
+// scraping.
+const metricsAddr = ":9100"
+
+// shutdownReconnectDelay is handed to Shutdown so clients know how long to
+// back off before reconnecting.
+const shutdownReconnectDelay = 5 * time.Second
+
+// shutdownGracePeriod bounds how long Shutdown waits for outbound queues
+// to drain before closing the remaining clients anyway.
+const shutdownGracePeriod = 30 * time.Second
+
+// serveClientManagerMetrics attaches manager's Prometheus collectors to a
+// fresh registry and serves it at GET /metrics.
+func serveClientManagerMetrics(manager *client.DefaultClientManager) {
+	registry := prometheus.NewRegistry()
+	if err := manager.Register(registry); err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+			panic(err)
+		}
+	}()
+}
+
+// handleShutdownSignal arranges for manager to drain gracefully on
+// SIGTERM, so a Kubernetes rolling restart can complete without dropping
+// in-flight messages.
+func handleShutdownSignal(manager *client.DefaultClientManager) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		defer cancel()
+		if err := manager.Shutdown(ctx, shutdownReconnectDelay); err != nil {
+			logger.E("shutdown: %v", err)
+		}
+		os.Exit(0)
+	}()
+}
+
+func main() {
+	db.Init()
+	dao.Init()
+
+	config, err := service.GetConfig()
+	if err != nil {
+		panic(err)
+	}
+	etcd := config.Etcd.Servers
+
+	manager := client.NewDefaultManager()
+	client.Manager = manager
+	serveClientManagerMetrics(manager)
+	handleShutdownSignal(manager)
+
+	server := gateway_service.NewServer(manager, &rpc.ServerOptions{
+		Name:        config.Gateway.Server.Name,
+		Network:     config.Gateway.Server.Network,
+		Addr:        config.Gateway.Server.Addr,
+		Port:        config.Gateway.Server.Port,
+		EtcdServers: etcd,
+	})
+
+	if err := server.Run(); err != nil {
+		panic(err)
+	}
+}
@@ -5,12 +5,13 @@ import "go_im/im/client"
 type TestApi struct{}
 
 type TestLoginRequest struct {
-	Uid    int64
-	Device int64
+	Uid      int64
+	Device   int64
+	Platform client.Platform
 }
 
 func (t *TestApi) TestLogin(info *RequestInfo, request *TestLoginRequest) error {
-	client.Manager.ClientSignIn(info.Uid, request.Uid, request.Device)
+	client.Manager.ClientSignIn(info.Uid, request.Uid, request.Device, request.Platform)
 	return nil
 }
 
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go_im/im/client/metrics"
+	"go_im/im/dao/uid"
+	"go_im/im/message"
+	"go_im/pkg/logger"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrInvalidToken is returned by an Authenticator when the presented
+// token does not verify, has expired, or cannot be parsed.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Authenticator verifies the token a client presents at sign-in and
+// extracts the identity ClientSignInWithToken should trust instead of
+// whatever the client claims.
+type Authenticator interface {
+	Verify(ctx context.Context, token string) (uid int64, device int64, platform Platform, exp time.Time, err error)
+}
+
+// TokenRevoker subscribes to a shared revocation stream (Redis pub/sub or
+// an RPC push) and delivers revoked (uid, device) pairs to onRevoke until
+// ctx is cancelled.
+type TokenRevoker interface {
+	Subscribe(ctx context.Context, onRevoke func(uid, device int64))
+}
+
+// ClientSignInWithToken verifies token via the configured Authenticator
+// and only then signs the connection in, using the uid/device/platform
+// the token itself asserts rather than anything the caller supplied. Use
+// this for real client connections; ClientSignIn remains available for
+// internal/trusted callers (e.g. TestApi).
+func (c *DefaultClientManager) ClientSignInWithToken(ctx context.Context, id int64, token string) error {
+	if c.authenticator == nil {
+		return errors.New("client: no Authenticator configured")
+	}
+	uid_, device, platform, exp, err := c.authenticator.Verify(ctx, token)
+	if err != nil {
+		logger.W("sign in rejected, invalid token: %v", err)
+		return ErrInvalidToken
+	}
+	if err := c.ClientSignIn(id, uid_, device, platform); err != nil {
+		return err
+	}
+	if c.expiryKicker != nil && !exp.IsZero() {
+		c.expiryKicker.schedule(uid_, device, exp)
+	}
+	return nil
+}
+
+// SetAuthenticator configures the Authenticator ClientSignInWithToken
+// uses to verify tokens before trusting the uid/device they claim.
+func (c *DefaultClientManager) SetAuthenticator(a Authenticator) {
+	c.authenticator = a
+}
+
+// EnableTokenRevocation subscribes to revoker so that a revoked (uid,
+// device) is kicked immediately, even while still within its token's
+// expiry.
+func (c *DefaultClientManager) EnableTokenRevocation(ctx context.Context, revoker TokenRevoker) {
+	revoker.Subscribe(ctx, c.handleTokenRevoked)
+}
+
+// EnableExpiryAutoKick starts the single background goroutine that scans
+// a min-heap of (exp, uid, device) scheduled by ClientSignInWithToken and
+// kicks sessions whose token has expired, instead of waking a timer per
+// client.
+func (c *DefaultClientManager) EnableExpiryAutoKick(ctx context.Context) {
+	c.expiryKicker = newExpiryKicker(c.handleTokenRevoked)
+	c.expiryKicker.run(ctx)
+}
+
+func (c *DefaultClientManager) handleTokenRevoked(uid_, device int64) {
+	ds := c.clients.get(uid_)
+	if ds == nil {
+		return
+	}
+	existing := ds.get(device)
+	if existing == nil {
+		return
+	}
+	logger.D("token revoked, uid=%d, device=%d", uid_, device)
+	existing.SetID(uid.GenTemp(), 0)
+	existing.EnqueueMessage(message.NewMessage(0, message.ActionNotifyTokenRevoked, "Your session has been revoked"))
+	existing.Exit()
+	platform := ds.platformOf(device)
+	ds.remove(device)
+	atomic.AddInt64(&c.clientOnline, -1)
+	c.metrics.LifecycleEvents.WithLabelValues(string(metrics.EventKickOut)).Inc()
+	c.metrics.OnlineClients.WithLabelValues(platform.String()).Dec()
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// reference Authenticator implementations
+
+// JWTAuthenticator verifies HMAC-signed JWTs whose claims carry uid,
+// device and platform.
+type JWTAuthenticator struct {
+	secret []byte
+}
+
+func NewJWTAuthenticator(secret []byte) *JWTAuthenticator {
+	return &JWTAuthenticator{secret: secret}
+}
+
+func (a *JWTAuthenticator) Verify(_ context.Context, token string) (int64, int64, Platform, time.Time, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return a.secret, nil
+	})
+	if err != nil {
+		return 0, 0, PlatformUnknown, time.Time{}, ErrInvalidToken
+	}
+	uid_, ok1 := claims["uid"].(float64)
+	device, ok2 := claims["device"].(float64)
+	platform, ok3 := claims["platform"].(float64)
+	exp, ok4 := claims["exp"].(float64)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return 0, 0, PlatformUnknown, time.Time{}, ErrInvalidToken
+	}
+	return int64(uid_), int64(device), Platform(platform), time.Unix(int64(exp), 0), nil
+}
+
+// OpaqueTokenAuthenticator verifies tokens by exact match against a
+// store of currently-issued opaque tokens, as an alternative to JWTs for
+// deployments that prefer server-side session state.
+type OpaqueTokenAuthenticator struct {
+	mu     sync.RWMutex
+	tokens map[string]opaqueSession
+}
+
+type opaqueSession struct {
+	uid      int64
+	device   int64
+	platform Platform
+	exp      time.Time
+}
+
+func NewOpaqueTokenAuthenticator() *OpaqueTokenAuthenticator {
+	return &OpaqueTokenAuthenticator{tokens: make(map[string]opaqueSession)}
+}
+
+// Issue registers token as valid for the given identity until exp. The
+// issuing RPC/login handler calls this after creating the session.
+func (a *OpaqueTokenAuthenticator) Issue(token string, uid, device int64, platform Platform, exp time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens[token] = opaqueSession{uid: uid, device: device, platform: platform, exp: exp}
+}
+
+func (a *OpaqueTokenAuthenticator) Verify(_ context.Context, token string) (int64, int64, Platform, time.Time, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for stored, session := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(token)) != 1 {
+			continue
+		}
+		if time.Now().After(session.exp) {
+			return 0, 0, PlatformUnknown, time.Time{}, ErrInvalidToken
+		}
+		return session.uid, session.device, session.platform, session.exp, nil
+	}
+	return 0, 0, PlatformUnknown, time.Time{}, ErrInvalidToken
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// expiryKicker: a min-heap of (exp, uid, device) scanned by one goroutine
+
+type expiryEntry struct {
+	exp    time.Time
+	uid    int64
+	device int64
+}
+
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].exp.Before(h[j].exp) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryEntry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// expiryKicker holds one heap of pending token expirations and wakes once
+// to kick whichever is due next, rather than running a timer per client.
+type expiryKicker struct {
+	mu     sync.Mutex
+	heap   expiryHeap
+	onKick func(uid, device int64)
+	wake   chan struct{}
+}
+
+func newExpiryKicker(onKick func(uid, device int64)) *expiryKicker {
+	return &expiryKicker{onKick: onKick, wake: make(chan struct{}, 1)}
+}
+
+func (k *expiryKicker) schedule(uid, device int64, exp time.Time) {
+	k.mu.Lock()
+	heap.Push(&k.heap, expiryEntry{exp: exp, uid: uid, device: device})
+	k.mu.Unlock()
+	select {
+	case k.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (k *expiryKicker) run(ctx context.Context) {
+	go func() {
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+		for {
+			k.mu.Lock()
+			var next time.Duration = time.Hour
+			if len(k.heap) > 0 {
+				next = time.Until(k.heap[0].exp)
+			}
+			k.mu.Unlock()
+			if next < 0 {
+				next = 0
+			}
+			timer.Reset(next)
+			select {
+			case <-ctx.Done():
+				return
+			case <-k.wake:
+			case <-timer.C:
+				k.popExpired()
+			}
+		}
+	}()
+}
+
+func (k *expiryKicker) popExpired() {
+	k.mu.Lock()
+	var due []expiryEntry
+	now := time.Now()
+	for len(k.heap) > 0 && !k.heap[0].exp.After(now) {
+		due = append(due, heap.Pop(&k.heap).(expiryEntry))
+	}
+	k.mu.Unlock()
+	for _, e := range due {
+		k.onKick(e.uid, e.device)
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpiryKickerKicksOnlyExpiredEntries(t *testing.T) {
+	var mu sync.Mutex
+	var kicked []int64
+
+	k := newExpiryKicker(func(uid, device int64) {
+		mu.Lock()
+		kicked = append(kicked, uid)
+		mu.Unlock()
+	})
+	k.schedule(1, 0, time.Now().Add(-time.Minute))
+	k.schedule(2, 0, time.Now().Add(time.Hour))
+	k.popExpired()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(kicked) != 1 || kicked[0] != 1 {
+		t.Fatalf("popExpired() kicked %v, want only uid 1", kicked)
+	}
+}
+
+func TestExpiryKickerRunWakesForDueEntry(t *testing.T) {
+	done := make(chan int64, 1)
+	k := newExpiryKicker(func(uid, device int64) {
+		done <- uid
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	k.run(ctx)
+	k.schedule(42, 0, time.Now().Add(10*time.Millisecond))
+
+	select {
+	case uid := <-done:
+		if uid != 42 {
+			t.Fatalf("onKick called with uid=%d, want 42", uid)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expiryKicker did not kick the due entry in time")
+	}
+}
@@ -1,7 +1,10 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"go_im/im/client/metrics"
+	"go_im/im/client/presence"
 	"go_im/im/conn"
 	"go_im/im/dao/uid"
 	"go_im/im/message"
@@ -11,6 +14,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var ErrClientClosed = errors.New("client closed")
@@ -22,25 +27,243 @@ type DefaultClientManager struct {
 	messageSent  int64
 	maxOnline    int64
 	startAt      int64
+
+	// gatewayID identifies this gateway instance to the distributed
+	// presence store; empty when distributed kick-out is disabled.
+	gatewayID string
+	presence  presence.Store
+
+	// policy decides which existing devices a new sign-in evicts. Falls
+	// back to SingleTerminalPolicy when not set.
+	policy MultiLoginPolicy
+
+	metrics *metrics.Collectors
+
+	// sendQueueLimit bounds a client's outbound queue depth before
+	// EnqueueMessage reports DeviceBackpressure instead of writing to it.
+	sendQueueLimit int
+	rateLimiter    *uidRateLimiter
+	offlineHandler OfflineHandler
+
+	// draining is set by Shutdown to make ClientConnected reject new
+	// connections while in-flight ones are given a chance to migrate.
+	draining       int32
+	deregisterHook func(ctx context.Context) error
+
+	authenticator Authenticator
+	expiryKicker  *expiryKicker
 }
 
 func NewDefaultManager() *DefaultClientManager {
 	ret := new(DefaultClientManager)
 	ret.clients = newClients()
 	ret.startAt = time.Now().Unix()
+	ret.policy = SingleTerminalPolicy{}
+	ret.metrics = metrics.New()
+	ret.sendQueueLimit = defaultSendQueueLimit
 	return ret
 }
 
+// SetSendQueueLimit overrides the default outbound queue depth a client
+// may reach before new messages are reported as DeviceBackpressure.
+func (c *DefaultClientManager) SetSendQueueLimit(limit int) {
+	c.sendQueueLimit = limit
+}
+
+// SetRateLimit enables per-uid token-bucket rate limiting: at most rps
+// messages per second sustained, with bursts up to burst.
+func (c *DefaultClientManager) SetRateLimit(rps float64, burst int) {
+	c.rateLimiter = newUidRateLimiter(rps, burst)
+}
+
+// SetOfflineHandler registers the hook invoked once per device that
+// EnqueueMessage could not deliver to immediately, so it can be queued
+// for replay on the device's next sign-in.
+func (c *DefaultClientManager) SetOfflineHandler(handler OfflineHandler) {
+	c.offlineHandler = handler
+}
+
+// SetDeregisterHook registers a callback that Shutdown runs before it
+// starts rejecting new connections and draining existing ones, so an
+// external registry (etcd, a load balancer) can stop routing traffic to
+// this gateway first.
+func (c *DefaultClientManager) SetDeregisterHook(hook func(ctx context.Context) error) {
+	c.deregisterHook = hook
+}
+
+// reconnectDelayMessage carries the delay, in seconds, that
+// ActionNotifyServerShutdown suggests clients wait before reconnecting so
+// that an orchestrator's rolling restart has time to bring up the
+// replacement gateway.
+func reconnectDelayMessage(delay time.Duration) *message.Message {
+	seconds := strconv.FormatFloat(delay.Seconds(), 'f', 0, 64)
+	return message.NewMessage(0, message.ActionNotifyServerShutdown, seconds)
+}
+
+// Shutdown drains the manager for a graceful process exit: it deregisters
+// from any external registry, stops accepting new connections, tells
+// every client to reconnect elsewhere after reconnectDelay, waits for
+// outbound queues to empty (bounded by ctx's deadline), then closes every
+// remaining client. Safe to call once.
+func (c *DefaultClientManager) Shutdown(ctx context.Context, reconnectDelay time.Duration) error {
+	if c.deregisterHook != nil {
+		if err := c.deregisterHook(ctx); err != nil {
+			logger.E("shutdown: deregister hook failed: %v", err)
+		}
+	}
+	atomic.StoreInt32(&c.draining, 1)
+
+	shutdownMsg := reconnectDelayMessage(reconnectDelay)
+	c.clients.m.RLock()
+	all := make([]IClient, 0, len(c.clients.clients))
+	for _, ds := range c.clients.clients {
+		ds.foreach(func(_ int64, cli IClient) {
+			all = append(all, cli)
+		})
+	}
+	c.clients.m.RUnlock()
+	for _, cli := range all {
+		_ = cli.EnqueueMessage(shutdownMsg)
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+drain:
+	for {
+		if c.allQueuesDrained(all) {
+			break drain
+		}
+		select {
+		case <-ctx.Done():
+			logger.W("shutdown: deadline reached with clients still draining")
+			break drain
+		case <-ticker.C:
+		}
+	}
+
+	for _, cli := range all {
+		cli.Exit()
+	}
+	logger.I("shutdown complete: %+v", c.GetManagerInfo())
+	return nil
+}
+
+// allQueuesDrained reports whether every client in clients that exposes a
+// QueueDepthReporter has an empty outbound queue.
+func (c *DefaultClientManager) allQueuesDrained(clients []IClient) bool {
+	for _, cli := range clients {
+		if reporter, ok := cli.(QueueDepthReporter); ok && reporter.QueueLen() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Register attaches the manager's Prometheus collectors to registry so an
+// embedding service can expose them, e.g. the gateway command's GET
+// /metrics; api_service itself does not serve them.
+func (c *DefaultClientManager) Register(registry *prometheus.Registry) error {
+	return c.metrics.Register(registry)
+}
+
+// QueueDepthReporter is implemented by IClient implementations that can
+// report their outbound send-queue depth, for the send_queue_depth
+// histogram sampled by StartQueueDepthSampling.
+type QueueDepthReporter interface {
+	QueueLen() int
+}
+
+// StartQueueDepthSampling periodically walks every connected client and,
+// for those implementing QueueDepthReporter, records their current
+// send-queue depth. Runs until ctx is cancelled.
+func (c *DefaultClientManager) StartQueueDepthSampling(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.sampleQueueDepths()
+			}
+		}
+	}()
+}
+
+func (c *DefaultClientManager) sampleQueueDepths() {
+	c.clients.m.RLock()
+	defer c.clients.m.RUnlock()
+	for _, ds := range c.clients.clients {
+		for device, cli := range ds.ds {
+			reporter, ok := cli.(QueueDepthReporter)
+			if !ok {
+				continue
+			}
+			platform := ds.platformOf(device)
+			c.metrics.SendQueueDepth.WithLabelValues(platform.String()).Observe(float64(reporter.QueueLen()))
+		}
+	}
+}
+
+// SetMultiLoginPolicy overrides the default single-terminal multi-login
+// policy, e.g. with WebMobilePolicy or OnePerCategoryPolicy as configured
+// by the operator.
+func (c *DefaultClientManager) SetMultiLoginPolicy(policy MultiLoginPolicy) {
+	c.policy = policy
+}
+
+// EnableDistributedPresence turns on cross-gateway kick-out: sign-ins are
+// recorded in store under gatewayID, and a background subscription kicks
+// any local client that another gateway claims. Call once during startup,
+// before the manager starts accepting connections.
+func (c *DefaultClientManager) EnableDistributedPresence(ctx context.Context, store presence.Store, gatewayID string) {
+	c.presence = store
+	c.gatewayID = gatewayID
+	store.Subscribe(ctx, gatewayID, c.handleRemoteKick)
+}
+
+// handleRemoteKick is invoked when another gateway has claimed (uid,
+// device) that this gateway currently holds locally.
+func (c *DefaultClientManager) handleRemoteKick(uid_, device int64) {
+	ds := c.clients.get(uid_)
+	if ds == nil {
+		return
+	}
+	existing := ds.get(device)
+	if existing == nil {
+		return
+	}
+	logger.D("remote kick-out, uid=%d, device=%d", uid_, device)
+	existing.SetID(uid.GenTemp(), 0)
+	existing.EnqueueMessage(message.NewMessage(0, message.ActionNotifyKickOut, "Your account is logged in on another device"))
+	existing.Exit()
+	platform := ds.platformOf(device)
+	ds.remove(device)
+	atomic.AddInt64(&c.clientOnline, -1)
+	c.metrics.LifecycleEvents.WithLabelValues(string(metrics.EventKickOut)).Inc()
+	c.metrics.OnlineClients.WithLabelValues(platform.String()).Dec()
+}
+
+// RejectedConnection is returned by ClientConnected instead of a
+// connection id while the manager is draining during Shutdown; the
+// front-end should respond to the underlying handshake with 503.
+const RejectedConnection int64 = -1
+
 // ClientConnected 当一个用户连接建立后, 由该方法创建 IClient 实例 Client 并管理该连接, 返回该由连接创建客户端的标识 id
 // 返回的标识 id 是一个临时 id, 后续连接认证后会改变
 func (c *DefaultClientManager) ClientConnected(conn conn.Connection) int64 {
+	if atomic.LoadInt32(&c.draining) != 0 {
+		logger.W("rejecting new connection, manager is shutting down")
+		return RejectedConnection
+	}
 	statistics.SConnEnter()
 
 	// 获取一个临时 uid 标识这个连接
 	connUid := uid.GenTemp()
 	ret := newClient(conn)
 	ret.SetID(connUid, 0)
-	c.clients.add(connUid, 0, ret)
+	c.clients.add(connUid, 0, PlatformUnknown, ret)
 
 	// 开始处理连接的消息
 	ret.Run()
@@ -48,13 +271,14 @@ func (c *DefaultClientManager) ClientConnected(conn conn.Connection) int64 {
 }
 
 func (c *DefaultClientManager) AddClient(uid int64, cs IClient) {
-	c.clients.add(uid, 0, cs)
+	c.clients.add(uid, 0, PlatformUnknown, cs)
 	atomic.AddInt64(&c.clientOnline, 1)
 }
 
-// ClientSignIn 客户端登录, id 为连接时使用的临时标识, uid 为z用户标识, device 用于区分不同设备
-func (c *DefaultClientManager) ClientSignIn(id, uid_ int64, device int64) error {
-	logger.D("client sign in temp-id=%d, uid=%d, device=%d", id, uid_, device)
+// ClientSignIn 客户端登录, id 为连接时使用的临时标识, uid 为z用户标识, device 用于区分不同设备,
+// platform 标识该设备的终端类型, 用于 MultiLoginPolicy 判断需要顶掉哪些已登录设备
+func (c *DefaultClientManager) ClientSignIn(id, uid_ int64, device int64, platform Platform) error {
+	logger.D("client sign in temp-id=%d, uid=%d, device=%d, platform=%s", id, uid_, device, platform)
 	tempDs := c.clients.get(id)
 	if tempDs == nil || tempDs.size() == 0 {
 		// 该客户端不存在
@@ -62,40 +286,107 @@ func (c *DefaultClientManager) ClientSignIn(id, uid_ int64, device int64) error
 		return nil
 	}
 	client := tempDs.get(0)
+	connectedAt := tempDs.connectedAtOf(0)
 	logged := c.clients.get(uid_)
 	if logged != nil && logged.size() > 0 {
-		// 多设备登录
-		existing := logged.get(device)
-		if existing != nil {
-			logger.D("multi device login mutex, uid=%d, device=%d", uid_, device)
+		// 多设备登录, 根据 policy 决定顶掉哪些已登录设备
+		for _, evictedDevice := range c.policy.EvictWhich(logged.platforms(), platform) {
+			existing := logged.get(evictedDevice)
+			if existing == nil {
+				continue
+			}
+			logger.D("multi device login mutex, uid=%d, device=%d", uid_, evictedDevice)
+			evictedPlatform := logged.platformOf(evictedDevice)
 			existing.SetID(uid.GenTemp(), 0)
 			// "Your account is logged in on another device"
 			existing.EnqueueMessage(message.NewMessage(0, message.ActionNotifyKickOut, "Your account is logged in on another device"))
 			existing.Exit()
-			logged.remove(device)
+			logged.remove(evictedDevice)
+			atomic.AddInt64(&c.clientOnline, -1)
+			c.metrics.LifecycleEvents.WithLabelValues(string(metrics.EventKickOut)).Inc()
+			c.metrics.OnlineClients.WithLabelValues(evictedPlatform.String()).Dec()
 		}
 		if logged.size() > 0 {
 			msg := "multi device login, device=" + strconv.FormatInt(device, 10)
 			_ = EnqueueMessage(uid_, message.NewMessage(0, message.ActionNotifyAccountLogin, msg))
 		}
-		logged.put(device, client)
+		logged.put(device, platform, client)
 	} else {
 		// 单设备登录
-		c.clients.add(uid_, device, client)
+		c.clients.add(uid_, device, platform, client)
+	}
+	// 保留 ClientConnected 时记录的连接时间, 用于统计连接时长
+	if ds := c.clients.get(uid_); ds != nil && connectedAt > 0 {
+		ds.connectedAt_[device] = connectedAt
 	}
 	client.SetID(uid_, device)
 	// 删除临时 id
 	c.clients.delete(id, 0)
 
+	if err := c.claimDistributedPresence(uid_, device); err != nil {
+		if err == presence.ErrOwnedByOther {
+			logger.W("presence: lost sign-in race for uid=%d, device=%d; rejecting local session", uid_, device)
+			c.rejectLocalSignIn(uid_, device, client)
+		}
+		return err
+	}
+
 	atomic.AddInt64(&c.clientOnline, 1)
 	max := atomic.LoadInt64(&c.maxOnline)
 	current := atomic.LoadInt64(&c.clientOnline)
 	if max < current {
 		atomic.StoreInt64(&c.maxOnline, current)
 	}
+	c.metrics.LifecycleEvents.WithLabelValues(string(metrics.EventSignIn)).Inc()
+	c.metrics.OnlineClients.WithLabelValues(platform.String()).Inc()
 	return nil
 }
 
+// claimDistributedPresence publishes this gateway's ownership of (uid,
+// device) to the shared presence store. If the store's CAS reports
+// ErrOwnedByOther, another gateway's claim is the newer one and this
+// gateway's local sign-in is the loser of the race: the caller must not
+// complete it. Otherwise, if a previous claim belonged to another
+// gateway, that gateway is asked to kick its now-stale local session. A
+// no-op when distributed presence is not enabled.
+func (c *DefaultClientManager) claimDistributedPresence(uid_, device int64) error {
+	if c.presence == nil {
+		return nil
+	}
+	ctx := context.Background()
+	entry := presence.Entry{
+		Uid:         uid_,
+		Device:      device,
+		GatewayID:   c.gatewayID,
+		ConnectedAt: time.Now().UnixNano(),
+	}
+	previous, err := c.presence.Acquire(ctx, entry)
+	if err == presence.ErrOwnedByOther {
+		return err
+	}
+	if err != nil {
+		logger.E("presence: failed to acquire uid=%d device=%d: %v", uid_, device, err)
+		return nil
+	}
+	if previous != nil && previous.GatewayID != c.gatewayID {
+		if pubErr := c.presence.Publish(ctx, previous.GatewayID, uid_, device); pubErr != nil {
+			logger.E("presence: failed to publish kick to gateway=%s: %v", previous.GatewayID, pubErr)
+		}
+	}
+	return nil
+}
+
+// rejectLocalSignIn undoes the local registration ClientSignIn already
+// performed before claimDistributedPresence discovered that another
+// gateway holds the winning claim on (uid, device): it tells cli to retry
+// elsewhere and removes it rather than completing the sign-in.
+func (c *DefaultClientManager) rejectLocalSignIn(uid_, device int64, cli IClient) {
+	cli.EnqueueMessage(message.NewMessage(0, message.ActionNotifyKickOut, "Your account is already signed in on another gateway"))
+	cli.SetID(uid.GenTemp(), 0)
+	cli.Exit()
+	c.clients.delete(uid_, device)
+}
+
 func (c *DefaultClientManager) ClientLogout(uid_ int64, device int64) error {
 	cl := c.clients.get(uid_)
 	if cl == nil || cl.size() == 0 {
@@ -108,43 +399,135 @@ func (c *DefaultClientManager) ClientLogout(uid_ int64, device int64) error {
 		return nil
 	}
 	logger.I("client logout, uid=%d, device=%d", uid_, device)
+	platform := cl.platformOf(device)
+	connectedAt := cl.connectedAtOf(device)
 	logDevice.SetID(uid.GenTemp(), 0)
 	logDevice.Exit()
 	cl.remove(device)
 	atomic.AddInt64(&c.clientOnline, -1)
 	statistics.SConnExit()
+	c.metrics.LifecycleEvents.WithLabelValues(string(metrics.EventSignOut)).Inc()
+	c.metrics.OnlineClients.WithLabelValues(platform.String()).Dec()
+	if connectedAt > 0 {
+		c.metrics.ConnectionLifetime.Observe(time.Since(time.Unix(connectedAt, 0)).Seconds())
+	}
+	if c.presence != nil {
+		if err := c.presence.Release(context.Background(), uid_, device); err != nil {
+			logger.E("presence: failed to release uid=%d device=%d: %v", uid_, device, err)
+		}
+	}
 	return nil
 }
 
-// EnqueueMessage to the client with the specified uid and device, device: pass 0 express all device.
-func (c *DefaultClientManager) EnqueueMessage(uid int64, device int64, msg *message.Message) error {
+// EnqueueMessage delivers msg to uid's device, or every device when
+// device is 0. A client implementing QueueDepthReporter is not written to
+// once its queue reaches the send-queue limit; other clients are written
+// to directly. A full send-queue, a
+// rate-limited uid, or a missing/closed device is reported in the
+// returned DeliveryReport and, if an OfflineHandler is registered, handed
+// to it for offline replay instead. The returned error preserves the
+// original ErrClientNotExist/ErrClientClosed contract callers already
+// depend on; the report carries the richer per-device breakdown.
+func (c *DefaultClientManager) EnqueueMessage(uid int64, device int64, msg *message.Message) (*DeliveryReport, error) {
+	return c.enqueueMessage(uid, device, PlatformUnknown, msg)
+}
+
+// EnqueueMessageToPlatform broadcasts to all of uid's devices connected
+// from platform only, e.g. to push a Web-only notification.
+func (c *DefaultClientManager) EnqueueMessageToPlatform(uid int64, platform Platform, msg *message.Message) (*DeliveryReport, error) {
+	return c.enqueueMessage(uid, 0, platform, msg)
+}
+
+// enqueueMessage is EnqueueMessage's shared implementation. device == 0
+// broadcasts to every device; platform != PlatformUnknown additionally
+// restricts that broadcast to devices connected from that platform.
+func (c *DefaultClientManager) enqueueMessage(uid int64, device int64, platform Platform, msg *message.Message) (*DeliveryReport, error) {
 	atomic.AddInt64(&c.messageSent, 1)
+	report := &DeliveryReport{Uid: uid, Devices: map[int64]DeviceStatus{}}
 
-	var err error = nil
 	ds := c.clients.get(uid)
 	if ds == nil || ds.size() == 0 {
-		return ErrClientNotExist
+		c.reportDevice(report, uid, device, DeviceNotExist, msg)
+		return report, ErrClientNotExist
+	}
+	if c.rateLimiter != nil && !c.rateLimiter.allow(uid) {
+		logger.W("%v, uid=%d", ErrRateLimited, uid)
+		ds.foreach(func(deviceId int64, cli IClient) {
+			if device != 0 && deviceId != device {
+				return
+			}
+			if platform != PlatformUnknown && ds.platformOf(deviceId) != platform {
+				return
+			}
+			c.reportDevice(report, uid, deviceId, DeviceRateLimited, msg)
+		})
+		return report, ErrRateLimited
 	}
 	if device != 0 {
 		d := ds.get(device)
 		if d == nil {
-			return ErrClientNotExist
+			c.reportDevice(report, uid, device, DeviceNotExist, msg)
+			return report, ErrClientNotExist
 		}
-		return d.EnqueueMessage(msg)
+		return report, c.deliverToDevice(report, uid, device, d, msg).err()
 	}
-	ds.foreach(func(deviceId int64, c IClient) {
-		if device != 0 && deviceId != device {
+	var err error
+	ds.foreach(func(deviceId int64, cli IClient) {
+		if platform != PlatformUnknown && ds.platformOf(deviceId) != platform {
 			return
 		}
-		if c.Closed() {
-			// the connection state changed during the delivery of the message
-			err = ErrClientClosed
-			return
-		} else {
-			err = c.EnqueueMessage(msg)
+		if e := c.deliverToDevice(report, uid, deviceId, cli, msg).err(); e != nil {
+			err = e
 		}
 	})
-	return err
+	return report, err
+}
+
+// deliverToDevice writes msg to a single already-resolved client,
+// honouring the configured send-queue backpressure limit, and returns the
+// device's status as found, before any OfflineHandler hand-off.
+func (c *DefaultClientManager) deliverToDevice(report *DeliveryReport, uid, device int64, cli IClient, msg *message.Message) DeviceStatus {
+	if cli.Closed() {
+		// the connection state changed during the delivery of the message
+		c.reportDevice(report, uid, device, DeviceClosed, msg)
+		return DeviceClosed
+	}
+	if reporter, ok := cli.(QueueDepthReporter); ok && c.sendQueueLimit > 0 && reporter.QueueLen() >= c.sendQueueLimit {
+		logger.W("%v, uid=%d, device=%d", ErrClientBackpressure, uid, device)
+		c.reportDevice(report, uid, device, DeviceBackpressure, msg)
+		return DeviceBackpressure
+	}
+	if err := cli.EnqueueMessage(msg); err != nil {
+		c.reportDevice(report, uid, device, DeviceClosed, msg)
+		return DeviceClosed
+	}
+	report.Devices[device] = DeviceDelivered
+	c.metrics.MessagesEnqueued.WithLabelValues(string(metrics.OutcomeSuccess)).Inc()
+	return DeviceDelivered
+}
+
+// reportDevice records a non-delivered outcome for device, emits the
+// matching metric, and hands the message to the OfflineHandler when one
+// is registered.
+func (c *DefaultClientManager) reportDevice(report *DeliveryReport, uid, device int64, status DeviceStatus, msg *message.Message) {
+	report.Devices[device] = status
+	c.metrics.MessagesEnqueued.WithLabelValues(string(c.deliveryOutcome(status))).Inc()
+	if c.offlineHandler != nil {
+		c.offlineHandler.HandleOffline(uid, device, msg)
+		report.Devices[device] = DeviceQueuedOffline
+	}
+}
+
+// deliveryOutcome maps a DeviceStatus to its metrics label.
+func (c *DefaultClientManager) deliveryOutcome(status DeviceStatus) metrics.Outcome {
+	switch status {
+	case DeviceDelivered:
+		return metrics.OutcomeSuccess
+	case DeviceClosed:
+		return metrics.OutcomeClientClosed
+	default:
+		return metrics.OutcomeClientNotExist
+	}
 }
 
 func (c *DefaultClientManager) isOnline(uid int64) bool {
@@ -197,19 +580,38 @@ func (c *DefaultClientManager) GetManagerInfo() ServerInfo {
 //////////////////////////////////////////////////////////////////////////////
 
 type devices struct {
-	ds map[int64]IClient
+	ds           map[int64]IClient
+	platforms_   map[int64]Platform
+	connectedAt_ map[int64]int64
 }
 
-func (d *devices) put(device int64, cli IClient) {
+func (d *devices) put(device int64, platform Platform, cli IClient) {
 	d.ds[device] = cli
+	d.platforms_[device] = platform
+	if _, ok := d.connectedAt_[device]; !ok {
+		d.connectedAt_[device] = time.Now().Unix()
+	}
 }
 
 func (d *devices) get(device int64) IClient {
 	return d.ds[device]
 }
 
+// platformOf and connectedAtOf report the platform and connection time
+// (set at ClientConnected) recorded for device, for metrics reported at
+// sign-out.
+func (d *devices) platformOf(device int64) Platform {
+	return d.platforms_[device]
+}
+
+func (d *devices) connectedAtOf(device int64) int64 {
+	return d.connectedAt_[device]
+}
+
 func (d *devices) remove(device int64) {
 	delete(d.ds, device)
+	delete(d.platforms_, device)
+	delete(d.connectedAt_, device)
 }
 
 func (d *devices) foreach(f func(device int64, c IClient)) {
@@ -221,6 +623,18 @@ func (d *devices) size() int {
 	return len(d.ds)
 }
 
+// platforms returns every currently signed-in device paired with the
+// platform it connected from, for MultiLoginPolicy to reason about. A
+// uid may have more than one device on the same platform, so this is a
+// slice rather than a map keyed by Platform.
+func (d *devices) platforms() []SignedInDevice {
+	ret := make([]SignedInDevice, 0, len(d.platforms_))
+	for device, platform := range d.platforms_ {
+		ret = append(ret, SignedInDevice{Platform: platform, Device: device})
+	}
+	return ret
+}
+
 type clients struct {
 	m       sync.RWMutex
 	clients map[int64]*devices
@@ -256,15 +670,15 @@ func (g *clients) contains(uid int64) bool {
 	return ok
 }
 
-func (g *clients) add(uid int64, device int64, c IClient) {
+func (g *clients) add(uid int64, device int64, platform Platform, c IClient) {
 	g.m.Lock()
 	defer g.m.Unlock()
 	cs, ok := g.clients[uid]
 	if ok {
-		cs.put(device, c)
+		cs.put(device, platform, c)
 	} else {
-		d := &devices{map[int64]IClient{}}
-		d.put(device, c)
+		d := &devices{ds: map[int64]IClient{}, platforms_: map[int64]Platform{}, connectedAt_: map[int64]int64{}}
+		d.put(device, platform, c)
 		g.clients[uid] = d
 	}
 }
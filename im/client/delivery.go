@@ -0,0 +1,136 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go_im/im/message"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrClientBackpressure is returned when a client's outbound send-queue is
+// already at its configured limit; the caller should treat the message as
+// undelivered rather than block or silently drop it.
+var ErrClientBackpressure = errors.New("client send queue is full")
+
+// ErrRateLimited is returned when delivery to a uid is rejected by the
+// per-uid token-bucket rate limiter.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// DeviceStatus describes what happened when EnqueueMessage tried to
+// deliver to a single device.
+type DeviceStatus string
+
+const (
+	DeviceDelivered     DeviceStatus = "delivered"
+	DeviceQueuedOffline DeviceStatus = "queued_offline"
+	DeviceRateLimited   DeviceStatus = "rate_limited"
+	DeviceBackpressure  DeviceStatus = "backpressure"
+	DeviceNotExist      DeviceStatus = "not_exist"
+	DeviceClosed        DeviceStatus = "closed"
+)
+
+// DeliveryReport records, per device, what EnqueueMessage did with a
+// message addressed to a uid.
+type DeliveryReport struct {
+	Uid     int64
+	Devices map[int64]DeviceStatus
+}
+
+// err maps a device's delivery status back to EnqueueMessage's original
+// error contract, for callers that still only check the error return.
+func (s DeviceStatus) err() error {
+	switch s {
+	case DeviceDelivered:
+		return nil
+	case DeviceNotExist:
+		return ErrClientNotExist
+	case DeviceBackpressure:
+		return ErrClientBackpressure
+	case DeviceRateLimited:
+		return ErrRateLimited
+	default:
+		return ErrClientClosed
+	}
+}
+
+// OfflineHandler is invoked once per device that EnqueueMessage could not
+// deliver to immediately (missing, rate-limited, back-pressured or
+// closed), so callers
+// can push the message to an offline store or a Kafka topic for replay on
+// the device's next sign-in.
+type OfflineHandler interface {
+	HandleOffline(uid int64, device int64, msg *message.Message)
+}
+
+// defaultSendQueueLimit bounds how deep a single client's outbound queue
+// may grow before EnqueueMessage refuses further writes to it.
+const defaultSendQueueLimit = 256
+
+// uidLimiterIdleTTL is how long a uid's bucket may go unused before
+// uidRateLimiter reclaims it, so a gateway that has served a very large
+// number of distinct uids doesn't hold one rate.Limiter per uid forever.
+const uidLimiterIdleTTL = 10 * time.Minute
+
+// uidLimiterSweepInterval bounds how often allow() scans for idle entries
+// to evict, so the sweep itself stays cheap relative to the lookups it
+// rides along with.
+const uidLimiterSweepInterval = time.Minute
+
+// limiterEntry pairs a uid's token bucket with the last time it was used,
+// so uidRateLimiter can tell which entries are idle.
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// uidRateLimiter hands out a token-bucket rate.Limiter per uid, creating
+// it lazily on first use with the manager's configured rate and burst,
+// and evicting it once it has gone unused for uidLimiterIdleTTL.
+type uidRateLimiter struct {
+	mu        sync.Mutex
+	limiters  map[int64]*limiterEntry
+	rps       rate.Limit
+	burst     int
+	lastSweep time.Time
+}
+
+func newUidRateLimiter(rps float64, burst int) *uidRateLimiter {
+	return &uidRateLimiter{
+		limiters: make(map[int64]*limiterEntry),
+		rps:      rate.Limit(rps),
+		burst:    burst,
+	}
+}
+
+func (u *uidRateLimiter) allow(uid int64) bool {
+	u.mu.Lock()
+	now := time.Now()
+	e, ok := u.limiters[uid]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(u.rps, u.burst)}
+		u.limiters[uid] = e
+	}
+	e.lastUsed = now
+	u.evictIdleLocked(now)
+	l := e.limiter
+	u.mu.Unlock()
+	return l.Allow()
+}
+
+// evictIdleLocked removes entries idle for longer than uidLimiterIdleTTL.
+// Called with u.mu held; rate-limited to once per uidLimiterSweepInterval
+// so it doesn't turn every allow() call into a full map scan.
+func (u *uidRateLimiter) evictIdleLocked(now time.Time) {
+	if now.Sub(u.lastSweep) < uidLimiterSweepInterval {
+		return
+	}
+	u.lastSweep = now
+	for uid, e := range u.limiters {
+		if now.Sub(e.lastUsed) >= uidLimiterIdleTTL {
+			delete(u.limiters, uid)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package client
+
+import "testing"
+
+func TestUidRateLimiterAllowsUpToBurstThenRejects(t *testing.T) {
+	limiter := newUidRateLimiter(1, 2)
+
+	if !limiter.allow(1) {
+		t.Fatal("first call within burst should be allowed")
+	}
+	if !limiter.allow(1) {
+		t.Fatal("second call within burst should be allowed")
+	}
+	if limiter.allow(1) {
+		t.Fatal("call beyond burst should be rejected")
+	}
+}
+
+func TestUidRateLimiterIsPerUid(t *testing.T) {
+	limiter := newUidRateLimiter(1, 1)
+
+	if !limiter.allow(1) {
+		t.Fatal("uid 1's first call should be allowed")
+	}
+	if limiter.allow(1) {
+		t.Fatal("uid 1's second call should be rejected")
+	}
+	if !limiter.allow(2) {
+		t.Fatal("uid 2 should have its own independent bucket")
+	}
+}
@@ -0,0 +1,96 @@
+// Package metrics holds the Prometheus collectors for the client manager's
+// connection and delivery state. DefaultClientManager.Register attaches
+// them to a *prometheus.Registry so the embedding service can expose
+// them, e.g. at GET /metrics in the gateway command; api_service itself
+// does not serve them.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "glideim"
+const subsystem = "client_manager"
+
+// Outcome labels the result of an EnqueueMessage delivery attempt.
+type Outcome string
+
+const (
+	OutcomeSuccess        Outcome = "success"
+	OutcomeClientClosed   Outcome = "client_closed"
+	OutcomeClientNotExist Outcome = "client_not_exist"
+)
+
+// Event labels a sign-in/sign-out/kick-out lifecycle transition.
+type Event string
+
+const (
+	EventSignIn  Event = "sign_in"
+	EventSignOut Event = "sign_out"
+	EventKickOut Event = "kick_out"
+)
+
+// Collectors bundles every metric the client manager reports. The zero
+// value is not usable; build one with New.
+type Collectors struct {
+	OnlineClients      *prometheus.GaugeVec
+	MessagesEnqueued   *prometheus.CounterVec
+	SendQueueDepth     *prometheus.HistogramVec
+	LifecycleEvents    *prometheus.CounterVec
+	ConnectionLifetime prometheus.Histogram
+}
+
+// New builds the collector set. Call Register to attach it to a registry.
+func New() *Collectors {
+	return &Collectors{
+		OnlineClients: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "online_clients",
+			Help:      "Number of currently connected clients, labeled by platform.",
+		}, []string{"platform"}),
+		MessagesEnqueued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "messages_enqueued_total",
+			Help:      "Messages handed to EnqueueMessage, labeled by delivery outcome.",
+		}, []string{"outcome"}),
+		SendQueueDepth: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "send_queue_depth",
+			Help:      "Per-client outbound send-queue depth, sampled periodically.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}, []string{"platform"}),
+		LifecycleEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "lifecycle_events_total",
+			Help:      "Sign-in, sign-out and kick-out events, labeled by event type.",
+		}, []string{"event"}),
+		ConnectionLifetime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "connection_lifetime_seconds",
+			Help:      "Seconds between ClientConnected and ClientLogout for a device.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 12),
+		}),
+	}
+}
+
+// Register attaches every collector to registry. Safe to call once per
+// registry per process.
+func (m *Collectors) Register(registry *prometheus.Registry) error {
+	for _, c := range []prometheus.Collector{
+		m.OnlineClients,
+		m.MessagesEnqueued,
+		m.SendQueueDepth,
+		m.LifecycleEvents,
+		m.ConnectionLifetime,
+	} {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
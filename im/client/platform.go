@@ -0,0 +1,115 @@
+package client
+
+// Platform identifies the kind of device a client connected from. It is
+// reported by the client at sign-in time and used by MultiLoginPolicy to
+// decide which other sessions, if any, a new login should evict.
+type Platform int
+
+const (
+	PlatformUnknown Platform = iota
+	PlatformIOS
+	PlatformAndroid
+	PlatformWeb
+	PlatformPC
+	PlatformMiniProgram
+)
+
+func (p Platform) String() string {
+	switch p {
+	case PlatformIOS:
+		return "ios"
+	case PlatformAndroid:
+		return "android"
+	case PlatformWeb:
+		return "web"
+	case PlatformPC:
+		return "pc"
+	case PlatformMiniProgram:
+		return "mini_program"
+	default:
+		return "unknown"
+	}
+}
+
+// SignedInDevice pairs a currently connected device with the platform it
+// signed in from, so two devices on the same platform can both be
+// represented (a map[Platform]int64 can only hold one).
+type SignedInDevice struct {
+	Platform Platform
+	Device   int64
+}
+
+// MultiLoginPolicy decides what happens to the other signed-in devices of
+// a uid when a new platform signs in.
+type MultiLoginPolicy interface {
+	// AllowCoexist reports whether an incoming sign-in on incoming may
+	// stay connected alongside an already-signed-in existing session.
+	AllowCoexist(existing, incoming Platform) bool
+
+	// EvictWhich returns the device ids, among signedIn, that must be
+	// kicked out because incoming is signing in.
+	EvictWhich(signedIn []SignedInDevice, incoming Platform) []int64
+}
+
+// mobileCategory groups platforms that compete for a single "mobile" slot
+// under the "one-per-category" policy.
+func mobileCategory(p Platform) bool {
+	return p == PlatformIOS || p == PlatformAndroid
+}
+
+// SingleTerminalPolicy allows exactly one signed-in device at a time,
+// regardless of platform: every new sign-in evicts all others.
+type SingleTerminalPolicy struct{}
+
+func (SingleTerminalPolicy) AllowCoexist(Platform, Platform) bool { return false }
+
+func (SingleTerminalPolicy) EvictWhich(signedIn []SignedInDevice, _ Platform) []int64 {
+	ret := make([]int64, 0, len(signedIn))
+	for _, d := range signedIn {
+		ret = append(ret, d.Device)
+	}
+	return ret
+}
+
+// WebMobilePolicy lets one web session and one mobile/PC session coexist,
+// but a new login still evicts any existing session of the same platform.
+type WebMobilePolicy struct{}
+
+func (WebMobilePolicy) AllowCoexist(existing, incoming Platform) bool {
+	if existing == incoming {
+		return false
+	}
+	return existing == PlatformWeb || incoming == PlatformWeb
+}
+
+func (w WebMobilePolicy) EvictWhich(signedIn []SignedInDevice, incoming Platform) []int64 {
+	var ret []int64
+	for _, d := range signedIn {
+		if !w.AllowCoexist(d.Platform, incoming) {
+			ret = append(ret, d.Device)
+		}
+	}
+	return ret
+}
+
+// OnePerCategoryPolicy keeps at most one connected device per platform
+// category (mobile, web, pc each have their own slot), evicting only the
+// device in the same category as the incoming sign-in.
+type OnePerCategoryPolicy struct{}
+
+func (OnePerCategoryPolicy) AllowCoexist(existing, incoming Platform) bool {
+	if mobileCategory(existing) && mobileCategory(incoming) {
+		return false
+	}
+	return existing != incoming
+}
+
+func (o OnePerCategoryPolicy) EvictWhich(signedIn []SignedInDevice, incoming Platform) []int64 {
+	var ret []int64
+	for _, d := range signedIn {
+		if !o.AllowCoexist(d.Platform, incoming) {
+			ret = append(ret, d.Device)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func evictedSet(devices []int64) map[int64]bool {
+	ret := make(map[int64]bool, len(devices))
+	for _, d := range devices {
+		ret[d] = true
+	}
+	return ret
+}
+
+func TestSingleTerminalPolicyEvictsEveryOtherDevice(t *testing.T) {
+	policy := SingleTerminalPolicy{}
+	signedIn := []SignedInDevice{{PlatformIOS, 1}, {PlatformWeb, 2}}
+
+	got := evictedSet(policy.EvictWhich(signedIn, PlatformAndroid))
+	want := evictedSet([]int64{1, 2})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EvictWhich() = %v, want %v", got, want)
+	}
+}
+
+func TestSingleTerminalPolicyEvictsSamePlatformDevice(t *testing.T) {
+	policy := SingleTerminalPolicy{}
+	signedIn := []SignedInDevice{{PlatformAndroid, 1}, {PlatformWeb, 2}}
+
+	got := evictedSet(policy.EvictWhich(signedIn, PlatformAndroid))
+	want := evictedSet([]int64{1, 2})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EvictWhich() = %v, want %v, a second same-platform sign-in must still evict the first", got, want)
+	}
+}
+
+func TestWebMobilePolicyLetsWebAndMobileCoexist(t *testing.T) {
+	policy := WebMobilePolicy{}
+	signedIn := []SignedInDevice{{PlatformWeb, 1}}
+
+	if got := policy.EvictWhich(signedIn, PlatformIOS); got != nil {
+		t.Errorf("EvictWhich() = %v, want none evicted", got)
+	}
+}
+
+func TestWebMobilePolicyEvictsSamePlatform(t *testing.T) {
+	policy := WebMobilePolicy{}
+	signedIn := []SignedInDevice{{PlatformIOS, 1}, {PlatformWeb, 2}}
+
+	got := evictedSet(policy.EvictWhich(signedIn, PlatformIOS))
+	want := evictedSet([]int64{1})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EvictWhich() = %v, want %v", got, want)
+	}
+}
+
+func TestOnePerCategoryPolicyEvictsOnlySameCategory(t *testing.T) {
+	policy := OnePerCategoryPolicy{}
+	signedIn := []SignedInDevice{{PlatformIOS, 1}, {PlatformWeb, 2}, {PlatformPC, 3}}
+
+	got := evictedSet(policy.EvictWhich(signedIn, PlatformAndroid))
+	want := evictedSet([]int64{1})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EvictWhich() = %v, want %v", got, want)
+	}
+}
+
+func TestOnePerCategoryPolicyAllowsDistinctCategories(t *testing.T) {
+	policy := OnePerCategoryPolicy{}
+
+	cases := []struct {
+		existing, incoming Platform
+		wantCoexist        bool
+	}{
+		{PlatformIOS, PlatformAndroid, false},
+		{PlatformIOS, PlatformWeb, true},
+		{PlatformWeb, PlatformPC, true},
+		{PlatformPC, PlatformPC, false},
+	}
+	for _, c := range cases {
+		if got := policy.AllowCoexist(c.existing, c.incoming); got != c.wantCoexist {
+			t.Errorf("AllowCoexist(%v, %v) = %v, want %v", c.existing, c.incoming, got, c.wantCoexist)
+		}
+	}
+}
+
+func TestPlatformString(t *testing.T) {
+	platforms := []Platform{PlatformUnknown, PlatformIOS, PlatformAndroid, PlatformWeb, PlatformPC, PlatformMiniProgram, Platform(99)}
+	var got []string
+	for _, p := range platforms {
+		got = append(got, p.String())
+	}
+	want := []string{"unknown", "ios", "android", "web", "pc", "mini_program", "unknown"}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("String() results = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+// Package presence tracks which gateway instance currently owns a
+// (uid, device) connection so that a sign-in on one gateway can kick the
+// same device's session running on another gateway.
+package presence
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrOwnedByOther is returned by Acquire when the (uid, device) entry is
+// currently owned by a different gateway and the caller asked for a
+// compare-and-swap that failed because of a concurrent sign-in.
+var ErrOwnedByOther = errors.New("presence: entry owned by another gateway")
+
+// Entry describes who currently owns a (uid, device) session.
+type Entry struct {
+	Uid         int64
+	Device      int64
+	GatewayID   string
+	ConnectedAt int64
+}
+
+// Store is the distributed presence backend. Implementations must make
+// Acquire atomic (e.g. a Lua script or WATCH/MULTI on Redis, or a CAS on
+// an etcd key) so that two gateways signing the same device in at the
+// same instant cannot both believe they own it.
+type Store interface {
+	// Acquire claims (entry.Uid, entry.Device) for entry.GatewayID. If the
+	// key is held by another gateway whose claim is at least as recent as
+	// entry's, nothing is written and the previous entry is returned
+	// alongside ErrOwnedByOther. Otherwise the claim is written and the
+	// previous entry, if any, is returned with a nil error so the caller
+	// can publish a kick to that entry's gateway.
+	Acquire(ctx context.Context, entry Entry) (previous *Entry, err error)
+
+	// Release drops ownership, e.g. on ClientLogout.
+	Release(ctx context.Context, uid, device int64) error
+
+	// Publish asks gatewayID to kick the given (uid, device) locally.
+	Publish(ctx context.Context, gatewayID string, uid, device int64) error
+
+	// Subscribe starts delivering kick requests addressed to gatewayID to
+	// onKick until ctx is cancelled. Implementations run their own
+	// receive loop in a background goroutine.
+	Subscribe(ctx context.Context, gatewayID string, onKick func(uid, device int64))
+}
+
+// entryTTL bounds how long a stale presence entry (gateway crashed
+// without calling Release) can keep blocking a new sign-in.
+const entryTTL = 2 * time.Minute
@@ -0,0 +1,109 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"go_im/pkg/logger"
+)
+
+// RedisStore implements Store on top of a shared Redis instance. Presence
+// entries are stored as `presence:{uid}:{device}` keys and kick requests
+// are delivered over a per-gateway pub/sub channel `presence:kick:{gatewayID}`.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func presenceKey(uid, device int64) string {
+	return fmt.Sprintf("presence:%d:%d", uid, device)
+}
+
+func kickChannel(gatewayID string) string {
+	return "presence:kick:" + gatewayID
+}
+
+// acquireScript atomically reads the current owner (if any) and overwrites
+// it with the new entry, returning the previous value. The write is
+// skipped when the key is already held by a different gateway whose claim
+// is at least as recent as ours, so a delayed Acquire from a losing
+// gateway can never clobber a newer claim; read, compare and write happen
+// in one Lua script so two simultaneous sign-ins resolve deterministically
+// instead of racing.
+var acquireScript = redis.NewScript(`
+local prev = redis.call("GET", KEYS[1])
+if prev then
+	local decoded = cjson.decode(prev)
+	if decoded.GatewayID ~= ARGV[3] and tonumber(decoded.ConnectedAt) >= tonumber(ARGV[4]) then
+		return prev
+	end
+end
+redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+return prev
+`)
+
+func (s *RedisStore) Acquire(ctx context.Context, entry Entry) (*Entry, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	key := presenceKey(entry.Uid, entry.Device)
+	res, err := acquireScript.Run(ctx, s.client, []string{key}, payload, int(entryTTL.Seconds()), entry.GatewayID, entry.ConnectedAt).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	raw, ok := res.(string)
+	if !ok || raw == "" {
+		return nil, nil
+	}
+	var previous Entry
+	if err := json.Unmarshal([]byte(raw), &previous); err != nil {
+		logger.W("presence: discarding malformed entry for key=%s: %v", key, err)
+		return nil, nil
+	}
+	if previous.GatewayID != entry.GatewayID && previous.ConnectedAt >= entry.ConnectedAt {
+		return &previous, ErrOwnedByOther
+	}
+	return &previous, nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, uid, device int64) error {
+	return s.client.Del(ctx, presenceKey(uid, device)).Err()
+}
+
+func (s *RedisStore) Publish(ctx context.Context, gatewayID string, uid, device int64) error {
+	payload, err := json.Marshal(Entry{Uid: uid, Device: device})
+	if err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, kickChannel(gatewayID), payload).Err()
+}
+
+func (s *RedisStore) Subscribe(ctx context.Context, gatewayID string, onKick func(uid, device int64)) {
+	sub := s.client.Subscribe(ctx, kickChannel(gatewayID))
+	ch := sub.Channel()
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var entry Entry
+				if err := json.Unmarshal([]byte(msg.Payload), &entry); err != nil {
+					logger.W("presence: bad kick payload on gateway=%s: %v", gatewayID, err)
+					continue
+				}
+				onKick(entry.Uid, entry.Device)
+			}
+		}
+	}()
+}